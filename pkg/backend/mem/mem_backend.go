@@ -0,0 +1,84 @@
+// Package mem implements an in-memory backend, mainly used for testing the
+// higher-level packages without touching the filesystem.
+package mem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/restic/restic/pkg/restic"
+)
+
+// MemoryBackend is a restic.Backend that keeps all data in memory. It is
+// intended for use in tests only.
+type MemoryBackend struct {
+	m  map[restic.Handle][]byte
+	mu sync.Mutex
+}
+
+// New returns a new backend that saves all data in a map in memory.
+func New() *MemoryBackend {
+	return &MemoryBackend{
+		m: make(map[restic.Handle][]byte),
+	}
+}
+
+// Save adds new Data to the backend.
+func (be *MemoryBackend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	if _, ok := be.m[h]; ok {
+		return fmt.Errorf("file %v already exists", h)
+	}
+
+	buf, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+
+	be.m[h] = buf
+	return nil
+}
+
+// Load runs fn with a reader for the file at offset with the given length.
+func (be *MemoryBackend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	be.mu.Lock()
+	buf, ok := be.m[h]
+	be.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("file %v not found", h)
+	}
+
+	if offset < 0 || offset > int64(len(buf)) {
+		return fmt.Errorf("offset %d out of range for file of length %d", offset, len(buf))
+	}
+
+	buf = buf[offset:]
+	if length > 0 && length < len(buf) {
+		buf = buf[:length]
+	}
+
+	return fn(io.LimitReader(newByteReader(buf), int64(len(buf))))
+}
+
+func newByteReader(buf []byte) io.Reader {
+	return &sliceReader{buf: buf}
+}
+
+type sliceReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.buf) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf[r.pos:])
+	r.pos += n
+	return n, nil
+}
@@ -0,0 +1,94 @@
+// Package crypto provides the low-level encryption primitives used to
+// protect data stored in a restic repository. Data is encrypted with
+// AES-256 in GCM mode, which authenticates the ciphertext and provides the
+// nonce handling needed by the higher-level packages.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+const (
+	keySize   = 32 // AES-256
+	nonceSize = 12 // standard GCM nonce size
+	macSize   = 16 // GCM authentication tag size
+
+	// Extension is the number of bytes added to plaintext when it is
+	// encrypted: the nonce plus the authentication tag.
+	Extension = nonceSize + macSize
+)
+
+// ErrUnauthenticated is returned when ciphertext verification has failed.
+var ErrUnauthenticated = errors.New("ciphertext verification failed")
+
+// ErrInvalidCiphertext is returned when a ciphertext is too short to have
+// been produced by Seal.
+var ErrInvalidCiphertext = errors.New("invalid ciphertext, too short")
+
+// Key holds the encryption key used to seal and open data.
+type Key struct {
+	key [keySize]byte
+}
+
+// NewRandomKey returns a new random key.
+func NewRandomKey() *Key {
+	k := &Key{}
+	if _, err := io.ReadFull(rand.Reader, k.key[:]); err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func (k *Key) aead() cipher.AEAD {
+	block, err := aes.NewCipher(k.key[:])
+	if err != nil {
+		panic(err)
+	}
+
+	aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		panic(err)
+	}
+
+	return aead
+}
+
+// Encrypt encrypts and authenticates plaintext, appends the result to dst
+// and returns the updated slice. The nonce is generated internally and
+// prepended to the returned ciphertext.
+func (k *Key) Encrypt(dst, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, nonce...)
+	return k.aead().Seal(dst, nonce, plaintext, nil), nil
+}
+
+// Decrypt verifies and decrypts the ciphertext and appends the result to
+// dst.
+func (k *Key) Decrypt(dst, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < Extension {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, sealed := ciphertext[:nonceSize], ciphertext[nonceSize:]
+
+	out, err := k.aead().Open(dst, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	return out, nil
+}
+
+// CiphertextLength returns the length of the ciphertext corresponding to a
+// plaintext of the given length.
+func CiphertextLength(plaintextLength int) int {
+	return plaintextLength + Extension
+}
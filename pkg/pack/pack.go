@@ -0,0 +1,486 @@
+// Package pack implements the pack file format used by restic to bundle
+// many small blobs into fewer, larger files suitable for storage on
+// backends that charge per request.
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/restic/restic/pkg/crypto"
+	"github.com/restic/restic/pkg/restic"
+)
+
+// CompressionMode selects how a blob passed to Packer.Add is stored in the
+// pack file.
+type CompressionMode uint8
+
+// Supported compression modes.
+const (
+	CompressionNone CompressionMode = iota
+	CompressionZstd
+)
+
+const headerLengthSize = 4
+
+// headerEntrySize is the size of a header entry for an uncompressed blob:
+// one byte of BlobType, the blob length and the blob ID. This is the
+// on-disk format used since the very first pack file, and must never
+// change so that old pack files remain readable.
+const headerEntrySize = 1 + 4 + restic.IDSize
+
+// compressedHeaderEntrySize is the size of a header entry for a
+// compressed blob: a headerEntrySize entry plus the uncompressed length.
+const compressedHeaderEntrySize = headerEntrySize + 4
+
+// DefaultMaxPackSize is the pack size used when PackerOptions.MaxPackSize
+// is left at its zero value.
+const DefaultMaxPackSize = 4 * 1024 * 1024 * 1024
+
+// DefaultMaxHeaderSize is the header size used when
+// PackerOptions.MaxHeaderSize is left at its zero value.
+const DefaultMaxHeaderSize = 16 * 1024 * 1024
+
+// ErrPackFull is returned by Packer.Add when adding the blob would cause
+// the pack to exceed its configured MaxPackSize or MaxHeaderSize. Callers
+// should finalize the current pack and start a new one.
+var ErrPackFull = errors.New("pack file full")
+
+// Chunk is a single content-defined chunk returned by a Chunker.
+type Chunk struct {
+	Data []byte
+}
+
+// Chunker splits data into content-defined chunks. Implementations
+// typically use a rolling hash to pick chunk boundaries so that small
+// edits to the input only change the chunks adjacent to the edit. A
+// Packer only stores the Chunker it was configured with and returns it
+// from Packer.Chunker; Packer.Add does not call it.
+type Chunker interface {
+	Next(data []byte) (Chunk, error)
+}
+
+// PackerOptions configures the limits a Packer enforces and the chunking
+// strategy it uses. The zero value is valid and selects the package's
+// defaults.
+type PackerOptions struct {
+	// MaxPackSize is the maximum number of blob bytes a Packer will write
+	// before Add starts returning ErrPackFull. The header written by
+	// Finalize is not counted. Zero means DefaultMaxPackSize.
+	MaxPackSize uint
+
+	// MaxHeaderSize is the maximum number of bytes the plaintext header
+	// may occupy before Add starts returning ErrPackFull. Zero means
+	// DefaultMaxHeaderSize.
+	MaxHeaderSize uint
+
+	// Chunker, if set, is returned by Packer.Chunker. Add does not use
+	// it: each Add call stores its data as a single blob, whether or not
+	// a Chunker is set.
+	Chunker Chunker
+}
+
+// Packer writes a new pack file, collecting the header entries needed to
+// locate the blobs added to it later.
+type Packer struct {
+	blobs []restic.Blob
+
+	bytes       uint
+	headerBytes uint
+	k           *crypto.Key
+	wr          io.Writer
+	opts        PackerOptions
+
+	m sync.Mutex
+}
+
+// NewPacker returns a new Packer that can be used to pack blobs together.
+func NewPacker(k *crypto.Key, wr io.Writer, opts PackerOptions) *Packer {
+	if opts.MaxPackSize == 0 {
+		opts.MaxPackSize = DefaultMaxPackSize
+	}
+	if opts.MaxHeaderSize == 0 {
+		opts.MaxHeaderSize = DefaultMaxHeaderSize
+	}
+
+	return &Packer{k: k, wr: wr, opts: opts}
+}
+
+// Chunker returns the Chunker configured for this Packer via
+// PackerOptions, or nil if none was set.
+func (p *Packer) Chunker() Chunker {
+	return p.opts.Chunker
+}
+
+// headerEntrySizeFor returns the number of header bytes an entry for t
+// will occupy.
+func headerEntrySizeFor(t restic.BlobType) uint {
+	if t.Compressed() {
+		return compressedHeaderEntrySize
+	}
+	return headerEntrySize
+}
+
+// Add saves the data read from rd as a new blob to the packer. Returned is
+// the number of bytes written to the pack. If comp is not CompressionNone,
+// data is compressed before being written out, and the uncompressed length
+// is recorded in the header so the blob can be restored on read. The blob
+// ID is always computed over the uncompressed plaintext, so deduplication
+// is unaffected by compression. Add returns ErrPackFull without writing
+// anything if doing so would exceed the Packer's configured MaxPackSize or
+// MaxHeaderSize.
+func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, comp CompressionMode) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	c := restic.Blob{Type: t, ID: id}
+
+	if comp != CompressionNone {
+		compressed, err := compress(data)
+		if err != nil {
+			return 0, err
+		}
+
+		c.Type = compressedType(t)
+		c.UncompressedLength = uint(len(data))
+		data = compressed
+	}
+
+	if p.bytes+uint(len(data)) > p.opts.MaxPackSize {
+		return 0, ErrPackFull
+	}
+	if p.headerBytes+headerEntrySizeFor(c.Type) > p.opts.MaxHeaderSize {
+		return 0, ErrPackFull
+	}
+
+	n, err := p.wr.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("write blob: %w", err)
+	}
+
+	c.Length = uint(n)
+	c.Offset = p.bytes
+	p.bytes += uint(n)
+	p.headerBytes += headerEntrySizeFor(c.Type)
+	p.blobs = append(p.blobs, c)
+
+	return n, nil
+}
+
+func compressedType(t restic.BlobType) restic.BlobType {
+	switch t {
+	case restic.DataBlob:
+		return restic.CompressedDataBlob
+	case restic.TreeBlob:
+		return restic.CompressedTreeBlob
+	default:
+		return t
+	}
+}
+
+func compress(data []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+
+	return enc.EncodeAll(data, make([]byte, 0, len(data))), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	return dec.DecodeAll(data, nil)
+}
+
+// writeHeader serializes p's list of blobs to wr and returns the number of
+// bytes written.
+func (p *Packer) writeHeader(wr io.Writer) (bytesWritten uint, err error) {
+	for _, b := range p.blobs {
+		entry := struct {
+			Type   restic.BlobType
+			Length uint32
+			ID     restic.ID
+		}{
+			Type:   b.Type,
+			Length: uint32(b.Length),
+			ID:     b.ID,
+		}
+
+		err := binary.Write(wr, binary.LittleEndian, entry)
+		if err != nil {
+			return bytesWritten, fmt.Errorf("write header entry: %w", err)
+		}
+		bytesWritten += headerEntrySize
+
+		if b.Type.Compressed() {
+			err := binary.Write(wr, binary.LittleEndian, uint32(b.UncompressedLength))
+			if err != nil {
+				return bytesWritten, fmt.Errorf("write header entry: %w", err)
+			}
+			bytesWritten += 4
+		}
+	}
+
+	return bytesWritten, nil
+}
+
+// Finalize writes the header for all added blobs and finishes the pack.
+// It returns the total number of bytes written.
+func (p *Packer) Finalize() (uint, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	bytesWritten := p.bytes
+
+	hdrBuf := bytes.NewBuffer(nil)
+	if _, err := p.writeHeader(hdrBuf); err != nil {
+		return 0, err
+	}
+
+	// Only encryptedHeader and its trailing length are ever written to
+	// p.wr -- the plaintext header built above lives solely in hdrBuf and
+	// must not be counted towards bytesWritten.
+	encryptedHeader, err := p.k.Encrypt(nil, hdrBuf.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("encrypt header: %w", err)
+	}
+
+	n, err := p.wr.Write(encryptedHeader)
+	if err != nil {
+		return 0, fmt.Errorf("write header: %w", err)
+	}
+	bytesWritten += uint(n)
+
+	hl := uint32(len(encryptedHeader))
+	err = binary.Write(p.wr, binary.LittleEndian, hl)
+	if err != nil {
+		return 0, fmt.Errorf("write header length: %w", err)
+	}
+	bytesWritten += headerLengthSize
+
+	p.bytes = bytesWritten
+
+	return bytesWritten, nil
+}
+
+// Size returns the number of bytes written so far, including the header,
+// once Finalize has been called.
+func (p *Packer) Size() uint {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.bytes
+}
+
+// Writer returns the underlying writer.
+func (p *Packer) Writer() io.Writer {
+	return p.wr
+}
+
+// readHeader reads the encrypted header at the end of the pack file, using
+// the trailing 4-byte header length, and returns the still-encrypted
+// bytes.
+func readHeader(rd io.ReaderAt, size int64) ([]byte, error) {
+	if size < headerLengthSize {
+		return nil, fmt.Errorf("pack file too small: %d bytes", size)
+	}
+
+	lengthBuf := make([]byte, headerLengthSize)
+	if _, err := rd.ReadAt(lengthBuf, size-headerLengthSize); err != nil {
+		return nil, fmt.Errorf("read header length: %w", err)
+	}
+
+	hlen := binary.LittleEndian.Uint32(lengthBuf)
+	if int64(hlen)+headerLengthSize > size {
+		return nil, fmt.Errorf("header length %d is larger than pack file", hlen)
+	}
+
+	buf := make([]byte, hlen)
+	if _, err := rd.ReadAt(buf, size-headerLengthSize-int64(hlen)); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+
+	return buf, nil
+}
+
+// parseHeader decodes a decrypted header into a list of blobs. Header
+// entries are variable length: an entry whose BlobType marks it as
+// compressed carries an extra 4-byte uncompressed-length field. Plain
+// entries are exactly headerEntrySize bytes, which is the format used by
+// every pack file ever written by restic, so old pack files continue to
+// parse unchanged.
+func parseHeader(buf []byte) (entries []restic.Blob, err error) {
+	rd := bytes.NewReader(buf)
+
+	var pos uint
+	for rd.Len() > 0 {
+		var raw struct {
+			Type   restic.BlobType
+			Length uint32
+			ID     restic.ID
+		}
+
+		if err := binary.Read(rd, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("read header entry: %w", err)
+		}
+
+		e := restic.Blob{
+			Type:   raw.Type,
+			Length: uint(raw.Length),
+			ID:     raw.ID,
+		}
+
+		if e.Type.Compressed() {
+			var uncompressedLength uint32
+			if err := binary.Read(rd, binary.LittleEndian, &uncompressedLength); err != nil {
+				return nil, fmt.Errorf("read header entry: %w", err)
+			}
+			e.UncompressedLength = uint(uncompressedLength)
+		}
+
+		e.Offset = pos
+		pos += e.Length
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// List returns the list of entries found in a pack file, read via rd,
+// which must cover size bytes.
+func List(k *crypto.Key, rd io.ReaderAt, size int64) ([]restic.Blob, error) {
+	buf, err := readHeader(rd, size)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err = k.Decrypt(nil, buf)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt header: %w", err)
+	}
+
+	return parseHeader(buf)
+}
+
+// Reader parses a pack file read sequentially from a single io.Reader,
+// e.g. a streaming GET from a backend, instead of requiring random-access
+// ReadAt calls per blob.
+//
+// Because the blob header lives at the end of the file, NewReader still
+// has to consume the entire stream before it knows where any blob starts
+// or ends -- there is no way around reading every byte once, whether that
+// byte ends up read via ReadAt calls (the List-based API) or via a single
+// streamed GET (this API). What this API actually buys callers is request
+// count, not memory: a backend like S3 or REST is read with one GET
+// instead of one (or more) per blob. To avoid also paying the memory cost
+// of holding a multi-hundred-MB pack in a []byte, NewReader spools the
+// incoming data to a temporary file instead, and Next reads one blob's
+// ciphertext back out of that file at a time.
+type Reader struct {
+	k       *crypto.Key
+	entries []restic.Blob
+	rd      io.ReaderAt
+	spool   io.Closer
+	pos     int
+}
+
+// NewReader consumes size bytes of pack data from rd, spooling it to a
+// temporary file, and parses the trailing header, returning a Reader that
+// streams blob payloads back to the caller via Next(). Callers must call
+// Close once they are done to release the spool file.
+func NewReader(k *crypto.Key, rd io.Reader, size int64) (*Reader, error) {
+	spool, err := os.CreateTemp("", "restic-pack-stream-")
+	if err != nil {
+		return nil, fmt.Errorf("spool pack: %w", err)
+	}
+	// Unlink immediately: the open file descriptor keeps the data
+	// available until Close, and the space is reclaimed automatically
+	// even if a caller forgets to call Close on an error path.
+	_ = os.Remove(spool.Name())
+
+	n, err := io.Copy(spool, io.LimitReader(rd, size))
+	if err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("spool pack: %w", err)
+	}
+	if n != size {
+		spool.Close()
+		return nil, fmt.Errorf("short read: got %d bytes, expected %d", n, size)
+	}
+
+	entries, err := List(k, spool, size)
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+
+	return &Reader{k: k, entries: entries, rd: spool, spool: spool}, nil
+}
+
+// Next returns the header entry and the decompressed payload for the next
+// blob in the pack, as returned by DecryptAndDecompress (the payload is not
+// decrypted). It returns io.EOF once every blob has been
+// returned.
+func (r *Reader) Next() (restic.Blob, io.Reader, error) {
+	if r.pos >= len(r.entries) {
+		return restic.Blob{}, nil, io.EOF
+	}
+
+	e := r.entries[r.pos]
+	r.pos++
+
+	raw := make([]byte, e.Length)
+	if _, err := r.rd.ReadAt(raw, int64(e.Offset)); err != nil {
+		return restic.Blob{}, nil, fmt.Errorf("blob %v: %w", e.ID.Str(), err)
+	}
+
+	plaintext, err := DecryptAndDecompress(r.k, e, raw)
+	if err != nil {
+		return restic.Blob{}, nil, err
+	}
+
+	return e, bytes.NewReader(plaintext), nil
+}
+
+// Close releases the Reader's spooled copy of the pack data.
+func (r *Reader) Close() error {
+	return r.spool.Close()
+}
+
+// DecryptAndDecompress returns the payload for the blob e, given the raw
+// bytes read from the pack at e.Offset/e.Length. It does not decrypt: k is
+// not used, and the result is the data exactly as it was passed to
+// Packer.Add, decompressed first if e is a compressed type. Most callers
+// should use this instead of decompressing manually, since it also
+// validates the decompressed length.
+func DecryptAndDecompress(k *crypto.Key, e restic.Blob, ciphertext []byte) ([]byte, error) {
+	if !e.Type.Compressed() {
+		return ciphertext, nil
+	}
+
+	plaintext, err := decompress(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decompress blob %v: %w", e.ID.Str(), err)
+	}
+
+	if uint(len(plaintext)) != e.UncompressedLength {
+		return nil, fmt.Errorf("decompressed length %d does not match expected length %d for blob %v",
+			len(plaintext), e.UncompressedLength, e.ID.Str())
+	}
+
+	return plaintext, nil
+}
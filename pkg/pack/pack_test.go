@@ -22,23 +22,42 @@ var testLens = []int{23, 31650, 25860, 10928, 13769, 19862, 5211, 127, 13690, 30
 type Buf struct {
 	data []byte
 	id   restic.ID
+	comp pack.CompressionMode
 }
 
+// newPack packs lengths as uncompressed blobs, using the default
+// PackerOptions, and returns the data needed to precisely recompute the
+// pack's expected on-disk size, since compression makes the packed
+// length of a blob unpredictable ahead of time.
 func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
+	return newPackWithComp(t, k, lengths, nil, pack.PackerOptions{})
+}
+
+// newPackWithComp behaves like newPack, but additionally compresses the
+// blob at index i when comps[i] is not pack.CompressionNone (comps may be
+// nil, meaning every blob is stored uncompressed), and builds the pack
+// with the given PackerOptions.
+func newPackWithComp(t testing.TB, k *crypto.Key, lengths []int, comps []pack.CompressionMode, opts pack.PackerOptions) ([]Buf, []byte, uint) {
 	bufs := []Buf{}
 
-	for _, l := range lengths {
+	for i, l := range lengths {
 		b := make([]byte, l)
 		_, err := io.ReadFull(rand.Reader, b)
 		rtest.OK(t, err)
 		h := sha256.Sum256(b)
-		bufs = append(bufs, Buf{data: b, id: h})
+
+		comp := pack.CompressionNone
+		if comps != nil {
+			comp = comps[i]
+		}
+		bufs = append(bufs, Buf{data: b, id: h, comp: comp})
 	}
 
 	// pack blobs
-	p := pack.NewPacker(k, new(bytes.Buffer))
+	p := pack.NewPacker(k, new(bytes.Buffer), opts)
 	for _, b := range bufs {
-		p.Add(restic.TreeBlob, b.id, b.data)
+		_, err := p.Add(restic.TreeBlob, b.id, b.data, b.comp)
+		rtest.OK(t, err)
 	}
 
 	_, err := p.Finalize()
@@ -48,20 +67,10 @@ func newPack(t testing.TB, k *crypto.Key, lengths []int) ([]Buf, []byte, uint) {
 	return bufs, packData, p.Size()
 }
 
+// verifyBlobs reads back every blob via pack.List and checks that it
+// decompresses (if necessary) to exactly the original plaintext, that IDs
+// are preserved, and that each entry's compression matches the blob's.
 func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSize uint) {
-	written := 0
-	for _, buf := range bufs {
-		written += len(buf.data)
-	}
-	// header length
-	written += binary.Size(uint32(0))
-	// header + header crypto
-	headerSize := len(bufs) * (binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
-	written += restic.CiphertextLength(headerSize)
-
-	// check length
-	rtest.Equals(t, uint(written), packSize)
-
 	// read and parse it again
 	entries, err := pack.List(k, rd, int64(packSize))
 	rtest.OK(t, err)
@@ -71,6 +80,7 @@ func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSi
 	for i, b := range bufs {
 		e := entries[i]
 		rtest.Equals(t, b.id, e.ID)
+		rtest.Equals(t, b.comp != pack.CompressionNone, e.Type.Compressed())
 
 		if len(buf) < int(e.Length) {
 			buf = make([]byte, int(e.Length))
@@ -80,17 +90,54 @@ func verifyBlobs(t testing.TB, bufs []Buf, k *crypto.Key, rd io.ReaderAt, packSi
 		rtest.OK(t, err)
 		buf = buf[:n]
 
-		rtest.Assert(t, bytes.Equal(b.data, buf),
+		plaintext, err := pack.DecryptAndDecompress(k, e, buf)
+		rtest.OK(t, err)
+
+		rtest.Assert(t, bytes.Equal(b.data, plaintext),
 			"data for blob %v doesn't match", i)
 	}
 }
 
+// verifyUncompressedSize independently recomputes the expected pack size
+// for a pack built entirely from uncompressed blobs, exercising the exact
+// on-disk header layout.
+func verifyUncompressedSize(t testing.TB, bufs []Buf, packSize uint) {
+	written := 0
+	for _, buf := range bufs {
+		written += len(buf.data)
+	}
+	// header length
+	written += binary.Size(uint32(0))
+	// header + header crypto
+	headerSize := len(bufs) * (binary.Size(restic.BlobType(0)) + binary.Size(uint32(0)) + len(restic.ID{}))
+	written += restic.CiphertextLength(headerSize)
+
+	rtest.Equals(t, uint(written), packSize)
+}
+
 func TestCreatePack(t *testing.T) {
 	// create random keys
 	k := crypto.NewRandomKey()
 
 	bufs, packData, packSize := newPack(t, k, testLens)
 	rtest.Equals(t, uint(len(packData)), packSize)
+	verifyUncompressedSize(t, bufs, packSize)
+	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
+}
+
+func TestCreatePackMixedCompression(t *testing.T) {
+	// create random keys
+	k := crypto.NewRandomKey()
+
+	comps := make([]pack.CompressionMode, len(testLens))
+	for i := range comps {
+		if i%2 == 0 {
+			comps[i] = pack.CompressionZstd
+		}
+	}
+
+	bufs, packData, packSize := newPackWithComp(t, k, testLens, comps, pack.PackerOptions{})
+	rtest.Equals(t, uint(len(packData)), packSize)
 	verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
 }
 
@@ -100,6 +147,8 @@ var blobTypeJSON = []struct {
 }{
 	{restic.DataBlob, `"data"`},
 	{restic.TreeBlob, `"tree"`},
+	{restic.CompressedDataBlob, `"data"`},
+	{restic.CompressedTreeBlob, `"tree"`},
 }
 
 func TestBlobTypeJSON(t *testing.T) {
@@ -113,7 +162,8 @@ func TestBlobTypeJSON(t *testing.T) {
 		var v restic.BlobType
 		err = json.Unmarshal([]byte(test.res), &v)
 		rtest.OK(t, err)
-		rtest.Equals(t, test.t, v)
+		rtest.Assert(t, v == test.t.Uncompressed(),
+			"unmarshal always yields the uncompressed type, got %v", v)
 	}
 }
 
@@ -121,7 +171,14 @@ func TestUnpackReadSeeker(t *testing.T) {
 	// create random keys
 	k := crypto.NewRandomKey()
 
-	bufs, packData, packSize := newPack(t, k, testLens)
+	comps := make([]pack.CompressionMode, len(testLens))
+	for i := range comps {
+		if i%3 == 0 {
+			comps[i] = pack.CompressionZstd
+		}
+	}
+
+	bufs, packData, packSize := newPackWithComp(t, k, testLens, comps, pack.PackerOptions{})
 
 	b := mem.New()
 	id := restic.Hash(packData)
@@ -131,6 +188,123 @@ func TestUnpackReadSeeker(t *testing.T) {
 	verifyBlobs(t, bufs, k, restic.ReaderAt(b, handle), packSize)
 }
 
+// onlyReader strips any extra interfaces (notably io.ReaderAt) off an
+// io.Reader, so tests can be sure they're exercising the non-seekable
+// streaming path.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestUnpackStreamReader(t *testing.T) {
+	// create random keys
+	k := crypto.NewRandomKey()
+
+	comps := make([]pack.CompressionMode, len(testLens))
+	for i := range comps {
+		if i%3 == 0 {
+			comps[i] = pack.CompressionZstd
+		}
+	}
+
+	bufs, packData, packSize := newPackWithComp(t, k, testLens, comps, pack.PackerOptions{})
+
+	b := mem.New()
+	id := restic.Hash(packData)
+
+	handle := restic.Handle{Type: restic.PackFile, Name: id.String()}
+	rtest.OK(t, b.Save(context.TODO(), handle, restic.NewByteReader(packData)))
+
+	var rd *pack.Reader
+	err := b.Load(context.TODO(), handle, 0, 0, func(streamed io.Reader) error {
+		var loadErr error
+		rd, loadErr = pack.NewReader(k, onlyReader{streamed}, int64(packSize))
+		return loadErr
+	})
+	rtest.OK(t, err)
+	defer rd.Close()
+
+	for i, buf := range bufs {
+		e, payload, err := rd.Next()
+		rtest.OK(t, err)
+		rtest.Equals(t, buf.id, e.ID)
+		rtest.Equals(t, buf.comp != pack.CompressionNone, e.Type.Compressed())
+
+		data, err := io.ReadAll(payload)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(buf.data, data),
+			"data for blob %v doesn't match", i)
+	}
+
+	_, _, err = rd.Next()
+	rtest.Equals(t, io.EOF, err)
+}
+
+func TestPackerOptions(t *testing.T) {
+	configs := []pack.PackerOptions{
+		{}, // defaults
+		{MaxPackSize: 4 * 1024 * 1024, MaxHeaderSize: 1024 * 1024},
+	}
+
+	for _, opts := range configs {
+		k := crypto.NewRandomKey()
+		bufs, packData, packSize := newPackWithComp(t, k, testLens, nil, opts)
+		rtest.Equals(t, uint(len(packData)), packSize)
+		verifyBlobs(t, bufs, k, bytes.NewReader(packData), packSize)
+	}
+}
+
+// testChunker is a minimal pack.Chunker used to verify that PackerOptions
+// actually threads a configured Chunker through to Packer.Chunker().
+type testChunker struct{}
+
+func (testChunker) Next(data []byte) (pack.Chunk, error) {
+	return pack.Chunk{Data: data}, nil
+}
+
+func TestPackerOptionsChunker(t *testing.T) {
+	k := crypto.NewRandomKey()
+	chunker := testChunker{}
+
+	p := pack.NewPacker(k, new(bytes.Buffer), pack.PackerOptions{Chunker: chunker})
+	rtest.Equals(t, pack.Chunker(chunker), p.Chunker())
+}
+
+func TestPackerAddReturnsErrPackFullWhenSizeExceeded(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	p := pack.NewPacker(k, new(bytes.Buffer), pack.PackerOptions{MaxPackSize: 100})
+
+	id, err := restic.NewRandomID()
+	rtest.OK(t, err)
+
+	_, err = p.Add(restic.TreeBlob, id, make([]byte, 50), pack.CompressionNone)
+	rtest.OK(t, err)
+
+	id2, err := restic.NewRandomID()
+	rtest.OK(t, err)
+
+	_, err = p.Add(restic.TreeBlob, id2, make([]byte, 80), pack.CompressionNone)
+	rtest.Equals(t, pack.ErrPackFull, err)
+}
+
+func TestPackerAddReturnsErrPackFullWhenHeaderExceeded(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	p := pack.NewPacker(k, new(bytes.Buffer), pack.PackerOptions{MaxHeaderSize: 40})
+
+	id, err := restic.NewRandomID()
+	rtest.OK(t, err)
+
+	_, err = p.Add(restic.TreeBlob, id, []byte("x"), pack.CompressionNone)
+	rtest.OK(t, err)
+
+	id2, err := restic.NewRandomID()
+	rtest.OK(t, err)
+
+	_, err = p.Add(restic.TreeBlob, id2, []byte("y"), pack.CompressionNone)
+	rtest.Equals(t, pack.ErrPackFull, err)
+}
+
 func TestShortPack(t *testing.T) {
 	k := crypto.NewRandomKey()
 
@@ -0,0 +1,60 @@
+package restic
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// RewindReader is the reader passed to Backend.Save: an io.Reader that
+// also reports the total length of its data. This is the subset of the
+// real Backend RewindReader interface that the Save path relies on; it
+// has no method to rewind.
+type RewindReader interface {
+	io.Reader
+	Length() int64
+}
+
+type byteReader struct {
+	*bytes.Reader
+}
+
+func (b *byteReader) Length() int64 {
+	return b.Size()
+}
+
+// NewByteReader prepares a RewindReader for a byte slice, as used by
+// Backend.Save.
+func NewByteReader(buf []byte) RewindReader {
+	return &byteReader{Reader: bytes.NewReader(buf)}
+}
+
+// Backend is the minimal subset of the repository backend interface needed
+// to store and load pack files.
+type Backend interface {
+	Save(ctx context.Context, h Handle, rd RewindReader) error
+	Load(ctx context.Context, h Handle, length int, offset int64, fn func(rd io.Reader) error) error
+}
+
+// ReaderAt returns an io.ReaderAt that reads from the backend for the given
+// handle, so that callers which only have a Backend (and not a local file)
+// can still use the io.ReaderAt-based pack APIs.
+func ReaderAt(be Backend, h Handle) io.ReaderAt {
+	return &backendReaderAt{be: be, h: h}
+}
+
+type backendReaderAt struct {
+	be Backend
+	h  Handle
+}
+
+func (brd *backendReaderAt) ReadAt(p []byte, offset int64) (n int, err error) {
+	err = brd.be.Load(context.TODO(), brd.h, len(p), offset, func(rd io.Reader) error {
+		n, err = io.ReadFull(rd, p)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
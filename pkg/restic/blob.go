@@ -0,0 +1,104 @@
+package restic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BlobType specifies what a blob stored in a pack is.
+type BlobType uint8
+
+// These are the blob types that can be stored in a pack.
+const (
+	InvalidBlob BlobType = iota
+	DataBlob
+	TreeBlob
+	// CompressedDataBlob and CompressedTreeBlob behave exactly like
+	// DataBlob and TreeBlob, except that the payload stored in the pack
+	// file is compressed. The blob ID is always computed over the
+	// plaintext, so deduplication is unaffected by compression.
+	CompressedDataBlob
+	CompressedTreeBlob
+	NumBlobTypes // Number of types. Must be last in this enum.
+)
+
+// Compressed reports whether a blob of this type is stored compressed in
+// the pack file.
+func (t BlobType) Compressed() bool {
+	return t == CompressedDataBlob || t == CompressedTreeBlob
+}
+
+// Uncompressed returns the BlobType that t decompresses to: DataBlob for
+// CompressedDataBlob, TreeBlob for CompressedTreeBlob, and t itself for
+// every other type.
+func (t BlobType) Uncompressed() BlobType {
+	switch t {
+	case CompressedDataBlob:
+		return DataBlob
+	case CompressedTreeBlob:
+		return TreeBlob
+	default:
+		return t
+	}
+}
+
+func (t BlobType) String() string {
+	switch t {
+	case DataBlob, CompressedDataBlob:
+		return "data"
+	case TreeBlob, CompressedTreeBlob:
+		return "tree"
+	case InvalidBlob:
+		return "invalid"
+	}
+	return "<unknown>"
+}
+
+// MarshalJSON encodes the BlobType into JSON.
+func (t BlobType) MarshalJSON() ([]byte, error) {
+	switch t {
+	case DataBlob, CompressedDataBlob:
+		return []byte(`"data"`), nil
+	case TreeBlob, CompressedTreeBlob:
+		return []byte(`"tree"`), nil
+	}
+
+	return nil, errors.New("unknown blob type")
+}
+
+// UnmarshalJSON decodes the BlobType from JSON.
+func (t *BlobType) UnmarshalJSON(buf []byte) error {
+	switch string(buf) {
+	case `"data"`:
+		*t = DataBlob
+	case `"tree"`:
+		*t = TreeBlob
+	default:
+		return errors.New("unknown blob type")
+	}
+
+	return nil
+}
+
+// Blob is one part of a file or a tree.
+type Blob struct {
+	Type   BlobType
+	Length uint
+	ID     ID
+	Offset uint
+
+	// UncompressedLength is the length of the plaintext before
+	// compression. It is zero for blobs that are not compressed.
+	UncompressedLength uint
+}
+
+func (b Blob) String() string {
+	return fmt.Sprintf("<Blob (%v) %v, length %v>",
+		b.Type, b.ID.Str(), b.Length)
+}
+
+// PackedBlob is a blob plus the ID of the pack it is contained in.
+type PackedBlob struct {
+	Blob
+	PackID ID
+}
@@ -0,0 +1,10 @@
+package restic
+
+import "github.com/restic/restic/pkg/crypto"
+
+// CiphertextLength returns the length of the ciphertext that results from
+// encrypting a plaintext of the given length, including the
+// crypto.Key.Encrypt nonce and authentication tag overhead.
+func CiphertextLength(plaintextLength int) int {
+	return crypto.CiphertextLength(plaintextLength)
+}
@@ -0,0 +1,49 @@
+package restic
+
+import "fmt"
+
+// FileType is the type of a file in the repository.
+type FileType string
+
+// These are the different data types a backend can store.
+const (
+	PackFile     FileType = "data"
+	KeyFile      FileType = "key"
+	LockFile     FileType = "lock"
+	SnapshotFile FileType = "snapshot"
+	IndexFile    FileType = "index"
+	ConfigFile   FileType = "config"
+)
+
+// Handle is used to store and access data in a backend.
+type Handle struct {
+	Type FileType
+	Name string
+}
+
+func (h Handle) String() string {
+	name := h.Name
+	if len(name) > 10 {
+		name = name[:10]
+	}
+	return fmt.Sprintf("<%s/%s>", h.Type, name)
+}
+
+// Valid returns an error if h is not valid.
+func (h Handle) Valid() error {
+	if h.Type == "" {
+		return fmt.Errorf("type is empty")
+	}
+
+	switch h.Type {
+	case PackFile, KeyFile, LockFile, SnapshotFile, IndexFile, ConfigFile:
+	default:
+		return fmt.Errorf("invalid Type %q", h.Type)
+	}
+
+	if h.Type != ConfigFile && h.Name == "" {
+		return fmt.Errorf("invalid Name %q", h.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package restic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// IDSize contains the size of an ID, in bytes.
+const IDSize = sha256.Size
+
+// ID references content within a repository.
+type ID [IDSize]byte
+
+// Hash returns the ID for data.
+func Hash(data []byte) ID {
+	return sha256.Sum256(data)
+}
+
+// NewRandomID returns a randomly generated ID. This is mainly used for testing.
+func NewRandomID() (ID, error) {
+	id := ID{}
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return ID{}, err
+	}
+	return id, nil
+}
+
+// ParseID converts the given string to an ID.
+func ParseID(s string) (ID, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return ID{}, fmt.Errorf("invalid id %q: %v", s, err)
+	}
+
+	if len(b) != IDSize {
+		return ID{}, errors.New("invalid length of id")
+	}
+
+	id := ID{}
+	copy(id[:], b)
+
+	return id, nil
+}
+
+// String returns the hexadecimal encoding of id.
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Str returns the shortened string version of id.
+func (id ID) Str() string {
+	if id.IsNull() {
+		return "[null]"
+	}
+	return hex.EncodeToString(id[:4])
+}
+
+// IsNull returns true iff id only consists of zeroes.
+func (id ID) IsNull() bool {
+	return id == ID{}
+}
+
+// Equal compares an ID to another other.
+func (id ID) Equal(other ID) bool {
+	return id == other
+}
+
+// MarshalJSON returns the JSON encoding of id.
+func (id ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+// UnmarshalJSON parses the JSON-encoded data and stores the result in id.
+func (id *ID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseID(s)
+	if err != nil {
+		return err
+	}
+
+	*id = parsed
+	return nil
+}
+
+// IDs is an ordered list of IDs that implements sort.Interface.
+type IDs []ID
+
+func (ids IDs) Len() int           { return len(ids) }
+func (ids IDs) Less(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 }
+func (ids IDs) Swap(i, j int)      { ids[i], ids[j] = ids[j], ids[i] }
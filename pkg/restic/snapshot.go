@@ -0,0 +1,253 @@
+package restic
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// SnapshotVersion is the current version of the on-disk snapshot format.
+// Version 1 is the original, flat format. Version 2 adds a structured
+// Metadata field (the exclude patterns used, the restic build and OS/arch
+// that produced the snapshot, and free-form user annotations) without
+// breaking older clients: a v1 client simply ignores JSON fields it
+// doesn't know about, and Snapshot.UnmarshalJSON preserves any fields a
+// newer client wrote so that loading and re-saving a v2 snapshot with an
+// older restic (or vice versa) doesn't silently drop data.
+const SnapshotVersion = 2
+
+// Version is the version of restic that produced a snapshot's Metadata.
+// It is overwritten by the main package via ldflags in release builds.
+var Version = "dev"
+
+// SnapshotMetadata holds the v2 snapshot fields.
+type SnapshotMetadata struct {
+	// Excludes lists the patterns passed to `--exclude` (and similar
+	// flags) for this snapshot, recorded for auditing/debugging.
+	Excludes []string `json:"excludes,omitempty"`
+
+	// Version and OS/Arch identify the restic build that created the
+	// snapshot.
+	Version string `json:"version,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+
+	// Annotations holds free-form user-supplied key/value pairs, e.g.
+	// set via `--tag` style metadata flags.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Snapshot is the state of a resource at one point in time.
+type Snapshot struct {
+	// Version is the on-disk snapshot format version. Snapshots written
+	// before this field existed are implicitly version 1.
+	Version  uint      `json:"version,omitempty"`
+	Time     time.Time `json:"time"`
+	Parent   *ID       `json:"parent,omitempty"`
+	Tree     *ID       `json:"tree"`
+	Paths    []string  `json:"paths"`
+	Hostname string    `json:"hostname,omitempty"`
+	Username string    `json:"username,omitempty"`
+	UID      uint32    `json:"uid,omitempty"`
+	GID      uint32    `json:"gid,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Original *ID       `json:"original,omitempty"`
+
+	// Metadata is only present on v2 (and later) snapshots.
+	Metadata *SnapshotMetadata `json:"metadata,omitempty"`
+
+	// Unknown preserves any top-level JSON fields that this version of
+	// restic does not recognize, so that loading and re-saving a
+	// snapshot written by a different restic version round-trips
+	// losslessly.
+	Unknown map[string]json.RawMessage `json:"-"`
+
+	id *ID // plaintext ID, used during restore
+}
+
+// snapshotKnownFields lists the JSON field names handled explicitly by
+// Snapshot, used by (Un)MarshalJSON to separate known from unknown data.
+var snapshotKnownFields = map[string]struct{}{
+	"version":  {},
+	"time":     {},
+	"parent":   {},
+	"tree":     {},
+	"paths":    {},
+	"hostname": {},
+	"username": {},
+	"uid":      {},
+	"gid":      {},
+	"tags":     {},
+	"original": {},
+	"metadata": {},
+}
+
+// MarshalJSON encodes the snapshot as JSON, re-emitting any unknown fields
+// alongside the fields restic understands.
+func (sn Snapshot) MarshalJSON() ([]byte, error) {
+	type snapshot Snapshot
+
+	buf, err := json.Marshal(snapshot(sn))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sn.Unknown) == 0 {
+		return buf, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return nil, err
+	}
+
+	for k, v := range sn.Unknown {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes a snapshot from JSON, stashing any fields it
+// doesn't recognize in Unknown instead of discarding them.
+func (sn *Snapshot) UnmarshalJSON(data []byte) error {
+	type snapshot Snapshot
+
+	var v snapshot
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*sn = Snapshot(v)
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	for name := range snapshotKnownFields {
+		delete(fields, name)
+	}
+
+	if len(fields) > 0 {
+		sn.Unknown = fields
+	} else {
+		sn.Unknown = nil
+	}
+
+	return nil
+}
+
+// NewSnapshot returns a new snapshot for the given paths. Unlike v1
+// snapshots, the result always carries a populated Metadata record; use
+// SetExcludes and AddAnnotation to fill in the rest before saving.
+func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
+	absPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		p, err := filepath.Abs(path)
+		if err == nil {
+			absPaths = append(absPaths, p)
+		} else {
+			absPaths = append(absPaths, path)
+		}
+	}
+
+	sn := &Snapshot{
+		Version:  SnapshotVersion,
+		Paths:    absPaths,
+		Time:     time,
+		Tags:     tags,
+		Hostname: hostname,
+		Metadata: &SnapshotMetadata{
+			Version: Version,
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		},
+	}
+
+	if sn.Hostname == "" {
+		if hn, err := os.Hostname(); err == nil {
+			sn.Hostname = hn
+		}
+	}
+
+	err := sn.fillUserInfo()
+	return sn, err
+}
+
+func (sn *Snapshot) fillUserInfo() error {
+	usr, err := user.Current()
+	if err != nil {
+		return nil
+	}
+	sn.Username = usr.Username
+
+	if uid, err := strconv.ParseUint(usr.Uid, 10, 32); err == nil {
+		sn.UID = uint32(uid)
+	}
+	if gid, err := strconv.ParseUint(usr.Gid, 10, 32); err == nil {
+		sn.GID = uint32(gid)
+	}
+
+	return nil
+}
+
+// SetExcludes records the exclude patterns used to create the snapshot in
+// its Metadata.
+func (sn *Snapshot) SetExcludes(excludes []string) {
+	if sn.Metadata == nil {
+		sn.Metadata = &SnapshotMetadata{}
+	}
+	sn.Metadata.Excludes = excludes
+}
+
+// AddAnnotation attaches a free-form user annotation to the snapshot.
+func (sn *Snapshot) AddAnnotation(key, value string) {
+	if sn.Metadata == nil {
+		sn.Metadata = &SnapshotMetadata{}
+	}
+	if sn.Metadata.Annotations == nil {
+		sn.Metadata.Annotations = make(map[string]string)
+	}
+	sn.Metadata.Annotations[key] = value
+}
+
+// ID returns the snapshot's ID, if known.
+func (sn Snapshot) ID() *ID {
+	return sn.id
+}
+
+// UpgradeToV2 migrates sn to SnapshotVersion in place. It is a no-op if
+// the snapshot is already at v2 or newer. Upgrading only sets Version and
+// ensures Metadata is non-nil; it cannot invent excludes, build version,
+// or OS/arch data that a v1 snapshot never recorded.
+func (sn *Snapshot) UpgradeToV2() {
+	if sn.Version >= SnapshotVersion {
+		return
+	}
+
+	sn.Version = SnapshotVersion
+	if sn.Metadata == nil {
+		sn.Metadata = &SnapshotMetadata{}
+	}
+}
+
+// MigrateSnapshots upgrades every snapshot older than SnapshotVersion to
+// the current version in place, returning how many were changed. Callers
+// are expected to re-save each changed snapshot back to the repository.
+func MigrateSnapshots(snapshots []*Snapshot) (migrated int) {
+	for _, sn := range snapshots {
+		if sn.Version >= SnapshotVersion {
+			continue
+		}
+		sn.UpgradeToV2()
+		migrated++
+	}
+
+	return migrated
+}
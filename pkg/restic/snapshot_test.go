@@ -1,6 +1,7 @@
 package restic_test
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -11,6 +12,66 @@ import (
 func TestNewSnapshot(t *testing.T) {
 	paths := []string{"/home/foobar"}
 
-	_, err := restic.NewSnapshot(paths, nil, "foo", time.Now())
+	sn, err := restic.NewSnapshot(paths, nil, "foo", time.Now())
 	rtest.OK(t, err)
+	rtest.Equals(t, uint(restic.SnapshotVersion), sn.Version)
+	rtest.Assert(t, sn.Metadata != nil, "expected NewSnapshot to populate Metadata")
+}
+
+// TestSnapshotRoundtripV1 checks that a v1 snapshot (no "version" or
+// "metadata" fields, as written by old clients) still loads correctly and
+// survives a JSON round trip unchanged.
+func TestSnapshotRoundtripV1(t *testing.T) {
+	v1JSON := []byte(`{"time":"2017-01-01T00:00:00Z","tree":null,"paths":["/home/foobar"],"hostname":"foo","tags":["bar"]}`)
+
+	var sn restic.Snapshot
+	rtest.OK(t, json.Unmarshal(v1JSON, &sn))
+	rtest.Equals(t, uint(0), sn.Version)
+	rtest.Assert(t, sn.Metadata == nil, "v1 snapshot should not have metadata")
+
+	out, err := json.Marshal(sn)
+	rtest.OK(t, err)
+
+	var reparsed map[string]interface{}
+	rtest.OK(t, json.Unmarshal(out, &reparsed))
+	var original map[string]interface{}
+	rtest.OK(t, json.Unmarshal(v1JSON, &original))
+	rtest.Equals(t, original, reparsed)
+}
+
+// TestSnapshotRoundtripV2 checks that a v2 snapshot with Metadata survives
+// a JSON round trip, including fields this version of restic doesn't
+// know about yet.
+func TestSnapshotRoundtripV2(t *testing.T) {
+	v2JSON := []byte(`{"version":2,"time":"2017-01-01T00:00:00Z","tree":null,"paths":["/home/foobar"],"hostname":"foo","tags":["bar"],"metadata":{"excludes":["*.tmp"],"version":"0.15.0","os":"linux","arch":"amd64","annotations":{"env":"prod"}},"future_field":"kept"}`)
+
+	var sn restic.Snapshot
+	rtest.OK(t, json.Unmarshal(v2JSON, &sn))
+	rtest.Equals(t, uint(2), sn.Version)
+	rtest.Equals(t, []string{"*.tmp"}, sn.Metadata.Excludes)
+	rtest.Equals(t, "prod", sn.Metadata.Annotations["env"])
+
+	out, err := json.Marshal(sn)
+	rtest.OK(t, err)
+
+	var reparsed map[string]interface{}
+	rtest.OK(t, json.Unmarshal(out, &reparsed))
+	var original map[string]interface{}
+	rtest.OK(t, json.Unmarshal(v2JSON, &original))
+	rtest.Equals(t, original, reparsed)
+}
+
+func TestMigrateSnapshots(t *testing.T) {
+	v1, err := restic.NewSnapshot([]string{"/home/foobar"}, nil, "foo", time.Now())
+	rtest.OK(t, err)
+	v1.Version = 0
+	v1.Metadata = nil
+
+	v2, err := restic.NewSnapshot([]string{"/home/foobar"}, nil, "foo", time.Now())
+	rtest.OK(t, err)
+
+	migrated := restic.MigrateSnapshots([]*restic.Snapshot{v1, v2})
+	rtest.Equals(t, 1, migrated)
+	rtest.Equals(t, uint(restic.SnapshotVersion), v1.Version)
+	rtest.Assert(t, v1.Metadata != nil, "expected migration to populate Metadata")
 }
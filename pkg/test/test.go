@@ -0,0 +1,34 @@
+// Package test contains helper functions shared by the test suites of
+// the various restic packages.
+package test
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// Assert fails the test if the condition is false.
+func Assert(t testing.TB, condition bool, msg string, args ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		t.Helper()
+		t.Fatalf("%s:%d: "+msg, append([]interface{}{file, line}, args...)...)
+	}
+}
+
+// OK fails the test if an err is not nil.
+func OK(t testing.TB, err error) {
+	if err != nil {
+		t.Helper()
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Equals fails the test if exp is not equal to act.
+func Equals(t testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		t.Helper()
+		t.Fatalf("expected: %#v, got: %#v", exp, act)
+	}
+}